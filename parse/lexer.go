@@ -3,7 +3,10 @@ package parse
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io"
+	"strings"
+	"unicode"
 )
 
 //Token identifies the type of data that was read
@@ -16,7 +19,11 @@ const (
 	WS             //WS identifies a whitespace
 
 	//Literals
-	IDENT //todos and dates
+	IDENT     //todos and dates
+	STRING    //quoted string, e.g. "apple pie"
+	NUMBER    //integer or decimal, e.g. 4 or 4.99
+	BADSTRING //string missing its closing quote
+	COMMENT   //a '#' or '//' line comment, only produced in ScanComments mode
 
 	//Key Symbols
 	STATUS_OPEN  //[
@@ -44,67 +51,241 @@ const (
 
 var eof = rune(0)
 
+//Mode is a bitmask of optional scanner behaviors, modeled on
+//text/scanner.Scanner.Mode.
+type Mode uint
+
+const (
+	//ScanComments makes Scan recognize '#' and '//' at the start of a line,
+	//after any leading whitespace, as the beginning of a COMMENT token
+	//instead of HASHTAG/SLASH. A '#'
+	//that is not at the start of a line is still scanned as HASHTAG, so
+	//inline hashtags keep working.
+	ScanComments Mode = 1 << iota
+	//SkipComments makes Scan silently consume COMMENT tokens instead of
+	//returning them to the caller. Only has an effect together with
+	//ScanComments.
+	SkipComments
+)
+
+//Pos describes a location within the scanned input. Line and Column are
+//both 1-indexed and count runes, not bytes. Offset is the 0-indexed byte
+//offset of the rune from the start of the input.
+type Pos struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+//String formats p as "line:column", e.g. "4:12", which is the form parse
+//errors should prefix onto their message.
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
 //Scanner represents a lexical scanner
 type scanner struct {
-	*bufio.Reader
+	r *bufio.Reader
+
+	//Mode enables optional scanner behaviors, see ScanComments and
+	//SkipComments. The zero value keeps the old behavior, where '#' is
+	//always HASHTAG and '/' is always SLASH.
+	Mode Mode
+
+	//IsIdentRune, if set, overrides which runes may appear after the
+	//first character of an ident. i is the rune's index within the ident
+	//(the first character, always a letter, is not passed through it).
+	//The zero value accepts unicode.IsLetter and unicode.IsDigit.
+	IsIdentRune func(ch rune, i int) bool
+
+	pos         Pos  //position of the rune that will be read next
+	lastPos     Pos  //position pos had before the last read, used to rewind unread and to report a rune's own position
+	atLineStart bool //true if only whitespace has been scanned since the start of input or the last line break
+
+	cache [2]cachedTok //ring of the most recently scanned tokens, cache[0] most recent
+	cn    int          //number of valid entries in cache
+	ci    int          //number of entries pending replay because of Unscan, 0 <= ci <= cn
+}
+
+//cachedTok is a single (Token, Pos, lit) triple held in the scanner's
+//peek/unscan ring.
+type cachedTok struct {
+	tok Token
+	pos Pos
+	lit string
 }
 
 //NewScanner returns a new instance of Scanner
 func NewScanner(r io.Reader) *scanner {
-	return &scanner{bufio.NewReader(r)}
+	return &scanner{r: bufio.NewReader(r), pos: Pos{Line: 1, Column: 1}, atLineStart: true}
 }
 
-//read reads the next rune from the buffered reader.
+//read reads the next rune from the buffered reader and advances pos.
 //Returns the rune(0) if an error occurs(or io.EOF is returned).
 func (s *scanner) read() rune {
-	r, _, err := s.ReadRune()
+	ch, size, err := s.r.ReadRune()
 	if err != nil {
 		return eof
 	}
 
-	return r
+	s.lastPos = s.pos
+	s.pos.Offset += size
+	if ch == '\n' {
+		s.pos.Line++
+		s.pos.Column = 1
+	} else {
+		s.pos.Column++
+	}
+
+	return ch
+}
+
+//unread pushes the last rune returned by read back onto the reader and
+//rewinds pos to where it was before that rune was read. Unreading a '\n'
+//restores the column it had on the line it ended, not column 1.
+func (s *scanner) unread() {
+	if err := s.r.UnreadRune(); err != nil {
+		return
+	}
+
+	s.pos = s.lastPos
+}
+
+//Scan returns the next token, the position of its first rune, and its value.
+//In SkipComments mode, COMMENT tokens are consumed internally and never
+//returned to the caller. A token returned by Scan can be pushed back onto
+//the scanner with Unscan.
+func (s *scanner) Scan() (tok Token, pos Pos, lit string) {
+	if s.ci > 0 {
+		s.ci--
+		t := s.cache[s.ci]
+		return t.tok, t.pos, t.lit
+	}
+
+	for {
+		tok, pos, lit = s.scan()
+		if tok == COMMENT && s.Mode&SkipComments != 0 {
+			continue
+		}
+		break
+	}
+
+	copy(s.cache[1:], s.cache[:len(s.cache)-1])
+	s.cache[0] = cachedTok{tok, pos, lit}
+	if s.cn < len(s.cache) {
+		s.cn++
+	}
+
+	return tok, pos, lit
 }
 
-//Scan returns the next token and its value
-func (s *scanner) Scan() (tok Token, lit string) {
+//Unscan pushes the token last returned by Scan back onto the scanner, so
+//the next call to Scan returns it again. Each further call in a row pushes
+//back the token scanned before that, up to len(cache) tokens in all; calls
+//beyond that have no effect. Pushed-back tokens are replayed in the order
+//they were originally scanned. Peek leaves its token pushed back, so an
+//Unscan right after Peek pushes back the token before the peeked one.
+func (s *scanner) Unscan() {
+	if s.ci < s.cn {
+		s.ci++
+	}
+}
+
+//Peek returns the next token and its value without consuming it: the
+//following Scan call returns the same token again.
+func (s *scanner) Peek() (tok Token, lit string) {
+	tok, _, lit = s.Scan()
+	s.Unscan()
+	return tok, lit
+}
+
+//scan is the unexported core of Scan; it additionally tracks atLineStart.
+func (s *scanner) scan() (tok Token, pos Pos, lit string) {
+	defer func() {
+		if tok != COMMENT {
+			if tok == WS && strings.ContainsRune(lit, '\n') {
+				s.atLineStart = true
+			} else if tok != WS {
+				s.atLineStart = false
+			}
+		}
+	}()
+
 	ch := s.read()
+	if ch == eof {
+		pos = s.pos
+	} else {
+		pos = s.lastPos
+	}
 
 	// If we see whitespace then consume all contiguous whitespace.
 	// If we see a letter then consume as an ident or reserved word.
+	// If we see a digit then consume as a number.
 	if isWhitespace(ch) {
-		s.UnreadRune()
-		return s.scanWhitespace()
-	} else if isLetter(ch) || isDigit(ch) {
-		s.UnreadRune()
-		return s.scanIdent()
+		s.unread()
+		tok, lit = s.scanWhitespace()
+		return tok, pos, lit
+	} else if isLetter(ch) {
+		s.unread()
+		tok, lit = s.scanIdent()
+		return tok, pos, lit
+	} else if isDigit(ch) {
+		s.unread()
+		tok, lit = s.scanNumber()
+		return tok, pos, lit
 	}
 
 	//Otherwise read individual character
 	switch ch {
 	case '#':
-		return HASHTAG, "#"
+		if s.Mode&ScanComments != 0 && s.atLineStart {
+			tok, lit = s.scanLineComment("#")
+			return tok, pos, lit
+		}
+		return HASHTAG, pos, "#"
 	case '[':
-		return STATUS_OPEN, "["
+		return STATUS_OPEN, pos, "["
 	case ']':
-		return STATUS_CLOSE, "]"
+		return STATUS_CLOSE, pos, "]"
 	case ',':
-		return COMMA, ","
+		return COMMA, pos, ","
 	case '.':
-		return DOT, "."
+		// A dot followed by a digit starts a fractional number like ".5".
+		if next := s.read(); isDigit(next) {
+			s.unread()
+			var buf bytes.Buffer
+			buf.WriteRune('.')
+			tok, lit = s.scanFraction(&buf)
+			return tok, pos, lit
+		} else {
+			s.unread()
+		}
+		return DOT, pos, "."
+	case '\'', '"':
+		tok, lit = s.scanString(ch)
+		return tok, pos, lit
 	case ':':
-		return COLON, ":"
+		return COLON, pos, ":"
 	case ';':
-		return SEMICOLON, ";"
+		return SEMICOLON, pos, ";"
 	case '/':
-		return SLASH, "/"
+		if s.Mode&ScanComments != 0 && s.atLineStart {
+			if next := s.read(); next == '/' {
+				tok, lit = s.scanLineComment("//")
+				return tok, pos, lit
+			} else {
+				s.unread()
+			}
+		}
+		return SLASH, pos, "/"
 	case '*':
-		return ASTERISK, "*"
+		return ASTERISK, pos, "*"
 	case '(':
 		fallthrough
 	case ')':
-		return BRACKET, string(ch)
+		return BRACKET, pos, string(ch)
 	case '~':
-		return TILDE, "~"
+		return TILDE, pos, "~"
 	case '€':
 		fallthrough
 	case '$':
@@ -112,26 +293,33 @@ func (s *scanner) Scan() (tok Token, lit string) {
 	case '£':
 		fallthrough
 	case '¥':
-		return CURRENCY_SIGN, string(ch)
+		return CURRENCY_SIGN, pos, string(ch)
 	case '§':
-		return PARAGRAPH, "§"
+		return PARAGRAPH, pos, "§"
 	case '&':
-		return AMPERSAND, "&"
+		return AMPERSAND, pos, "&"
 	case '=':
-		return EQUALS, "="
+		return EQUALS, pos, "="
 	case '@':
-		return AT, "@"
+		return AT, pos, "@"
 	case '%':
-		return PERCENT, "%"
+		return PERCENT, pos, "%"
 	case '-':
-		return DASH, "-"
+		return DASH, pos, "-"
 	case '_':
-		return UNDERSCORE, "_"
+		return UNDERSCORE, pos, "_"
 	case eof:
-		return EOF, string(ch)
+		return EOF, pos, string(ch)
 	}
 
-	return ILLEGAL, string(ch)
+	return ILLEGAL, pos, string(ch)
+}
+
+//ScanLit is a compatibility shim for callers that only need the token and
+//its literal value and don't care about the source position.
+func (s *scanner) ScanLit() (tok Token, lit string) {
+	tok, _, lit = s.Scan()
+	return tok, lit
 }
 
 func (s *scanner) scanWhitespace() (tok Token, lit string) {
@@ -145,7 +333,7 @@ func (s *scanner) scanWhitespace() (tok Token, lit string) {
 		if ch := s.read(); ch == eof {
 			break
 		} else if !isWhitespace(ch) {
-			s.UnreadRune()
+			s.unread()
 			break
 		} else {
 			buf.WriteRune(ch)
@@ -162,11 +350,11 @@ func (s *scanner) scanIdent() (tok Token, lit string) {
 	//Read every subsequent ident character into the buffer.
 	//Non ident Characters and EOF will cause the loop to exit
 
-	for {
+	for i := 1; ; i++ {
 		if ch := s.read(); ch == eof {
 			break
-		} else if !isLetter(ch) && !isDigit(ch) {
-			s.UnreadRune()
+		} else if !s.isIdentRune(ch, i) {
+			s.unread()
 			break
 		} else {
 			buf.WriteRune(ch)
@@ -176,19 +364,118 @@ func (s *scanner) scanIdent() (tok Token, lit string) {
 	return IDENT, buf.String()
 }
 
+//isIdentRune reports whether ch may appear at index i of an ident, where
+//the ident's first character has index 0; it is only called for i >= 1.
+func (s *scanner) isIdentRune(ch rune, i int) bool {
+	if s.IsIdentRune != nil {
+		return s.IsIdentRune(ch, i)
+	}
+	return isLetter(ch) || isDigit(ch)
+}
+
+//scanNumber consumes a run of digits, optionally followed by a '.' and more
+//digits, and returns it as a NUMBER token. A '.' right after the digits is
+//always part of the NUMBER, even when no digit follows it, e.g. "4.".
+func (s *scanner) scanNumber() (tok Token, lit string) {
+	var buf bytes.Buffer
+	buf.WriteRune(s.read())
+
+	for {
+		if ch := s.read(); ch == eof {
+			break
+		} else if ch == '.' {
+			buf.WriteRune(ch)
+			return s.scanFraction(&buf)
+		} else if !isDigit(ch) {
+			s.unread()
+			break
+		} else {
+			buf.WriteRune(ch)
+		}
+	}
+
+	return NUMBER, buf.String()
+}
+
+//scanFraction consumes the digits after the decimal point of a NUMBER whose
+//integer (or empty) part is already in buf, and returns the combined NUMBER.
+func (s *scanner) scanFraction(buf *bytes.Buffer) (tok Token, lit string) {
+	for {
+		if ch := s.read(); ch == eof {
+			break
+		} else if !isDigit(ch) {
+			s.unread()
+			break
+		} else {
+			buf.WriteRune(ch)
+		}
+	}
+
+	return NUMBER, buf.String()
+}
+
+//scanString consumes runes up to the matching quote, resolving \n, \t, \\,
+//\" and \' escape sequences, and returns the unescaped content as a STRING.
+//If EOF is reached before the closing quote it returns BADSTRING with
+//whatever was read so far.
+func (s *scanner) scanString(quote rune) (tok Token, lit string) {
+	var buf bytes.Buffer
+
+	for {
+		ch := s.read()
+		switch ch {
+		case quote:
+			return STRING, buf.String()
+		case eof:
+			return BADSTRING, buf.String()
+		case '\\':
+			switch esc := s.read(); esc {
+			case 'n':
+				buf.WriteRune('\n')
+			case 't':
+				buf.WriteRune('\t')
+			case '\\', '"', '\'':
+				buf.WriteRune(esc)
+			case eof:
+				return BADSTRING, buf.String()
+			default:
+				buf.WriteRune('\\')
+				buf.WriteRune(esc)
+			}
+		default:
+			buf.WriteRune(ch)
+		}
+	}
+}
+
+//scanLineComment consumes runes up to (but not including) the next line
+//break or EOF and returns them, prefixed with prefix, as a COMMENT.
+func (s *scanner) scanLineComment(prefix string) (tok Token, lit string) {
+	var buf bytes.Buffer
+	buf.WriteString(prefix)
+
+	for {
+		if ch := s.read(); ch == eof {
+			break
+		} else if ch == '\n' {
+			s.unread()
+			break
+		} else {
+			buf.WriteRune(ch)
+		}
+	}
+
+	return COMMENT, buf.String()
+}
+
 func isWhitespace(ch rune) bool {
-	return ch == ' ' || ch == '\t' || ch == '\n'
+	return unicode.IsSpace(ch)
 }
 
 func isLetter(ch rune) bool {
-	return (ch >= 'a' && ch <= 'z') ||
-		(ch >= 'A' && ch <= 'Z') ||
-		ch == 'ä' || ch == 'Ö' ||
-		ch == 'ö' || ch == 'Ä' ||
-		ch == 'ü' || ch == 'Ü' ||
-		ch == 'ß'
+	return unicode.IsLetter(ch)
 }
 
 func isDigit(ch rune) bool {
-	return ch >= '0' && ch <= '9'
+	return unicode.IsDigit(ch)
 }
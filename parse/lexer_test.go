@@ -0,0 +1,283 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestScanPos(t *testing.T) {
+	tests := []struct {
+		input string
+		toks  []Token
+		poss  []Pos
+	}{
+		{
+			input: "[x]",
+			toks:  []Token{STATUS_OPEN, IDENT, STATUS_CLOSE, EOF},
+			poss: []Pos{
+				{Offset: 0, Line: 1, Column: 1},
+				{Offset: 1, Line: 1, Column: 2},
+				{Offset: 2, Line: 1, Column: 3},
+				{Offset: 3, Line: 1, Column: 4},
+			},
+		},
+		{
+			// Multi-byte runes must be counted as one column each, not one
+			// per byte, and a line break must reset the column and bump
+			// the line.
+			input: "schön\nknihu",
+			toks:  []Token{IDENT, WS, IDENT, EOF},
+			poss: []Pos{
+				{Offset: 0, Line: 1, Column: 1},
+				{Offset: 6, Line: 1, Column: 6},
+				{Offset: 7, Line: 2, Column: 1},
+				{Offset: 12, Line: 2, Column: 6},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		s := NewScanner(strings.NewReader(tt.input))
+		for i, wantTok := range tt.toks {
+			tok, pos, _ := s.Scan()
+			if tok != wantTok {
+				t.Fatalf("%q: token %d: got %v, want %v", tt.input, i, tok, wantTok)
+			}
+			if pos != tt.poss[i] {
+				t.Fatalf("%q: token %d: got pos %s, want %s", tt.input, i, fmt.Sprintf("%#v", pos), fmt.Sprintf("%#v", tt.poss[i]))
+			}
+		}
+	}
+}
+
+func TestScanUnreadRewindsNewline(t *testing.T) {
+	// Scanning "a\nb" must leave the "\n" token's column where the
+	// newline itself sits (end of line 1), not line 2 column 1.
+	s := NewScanner(strings.NewReader("a\nb"))
+
+	tok, pos, lit := s.Scan()
+	if tok != IDENT || lit != "a" || pos != (Pos{Offset: 0, Line: 1, Column: 1}) {
+		t.Fatalf("got (%v, %#v, %q)", tok, pos, lit)
+	}
+
+	tok, pos, lit = s.Scan()
+	if tok != WS || lit != "\n" || pos != (Pos{Offset: 1, Line: 1, Column: 2}) {
+		t.Fatalf("got (%v, %#v, %q)", tok, pos, lit)
+	}
+
+	tok, pos, lit = s.Scan()
+	if tok != IDENT || lit != "b" || pos != (Pos{Offset: 2, Line: 2, Column: 1}) {
+		t.Fatalf("got (%v, %#v, %q)", tok, pos, lit)
+	}
+}
+
+func TestScanLit(t *testing.T) {
+	s := NewScanner(strings.NewReader("[x]"))
+
+	tok, lit := s.ScanLit()
+	if tok != STATUS_OPEN || lit != "[" {
+		t.Fatalf("got (%v, %q), want (%v, %q)", tok, lit, STATUS_OPEN, "[")
+	}
+}
+
+func TestScanString(t *testing.T) {
+	tests := []struct {
+		input string
+		tok   Token
+		lit   string
+	}{
+		{`"apple pie"`, STRING, "apple pie"},
+		{`'apple pie'`, STRING, "apple pie"},
+		{`"a\nb\tc\\d\"e"`, STRING, "a\nb\tc\\d\"e"},
+		{`"unterminated`, BADSTRING, "unterminated"},
+		{`"cut off\`, BADSTRING, "cut off"},
+	}
+
+	for _, tt := range tests {
+		s := NewScanner(strings.NewReader(tt.input))
+		tok, _, lit := s.Scan()
+		if tok != tt.tok || lit != tt.lit {
+			t.Errorf("%q: got (%v, %q), want (%v, %q)", tt.input, tok, lit, tt.tok, tt.lit)
+		}
+	}
+}
+
+func TestScanComments(t *testing.T) {
+	input := "# shopping list\nbuy milk\n  // rework this\nfoo #tag"
+	s := NewScanner(strings.NewReader(input))
+	s.Mode = ScanComments
+
+	want := []struct {
+		tok Token
+		lit string
+	}{
+		{COMMENT, "# shopping list"},
+		{WS, "\n"},
+		{IDENT, "buy"},
+		{WS, " "},
+		{IDENT, "milk"},
+		{WS, "\n  "},
+		{COMMENT, "// rework this"},
+		{WS, "\n"},
+		{IDENT, "foo"},
+		{WS, " "},
+		{HASHTAG, "#"},
+		{IDENT, "tag"},
+		{EOF, "\x00"},
+	}
+
+	for i, w := range want {
+		tok, _, lit := s.Scan()
+		if tok != w.tok || lit != w.lit {
+			t.Fatalf("token %d: got (%v, %q), want (%v, %q)", i, tok, lit, w.tok, w.lit)
+		}
+	}
+}
+
+func TestScanSkipComments(t *testing.T) {
+	s := NewScanner(strings.NewReader("# note\nfoo"))
+	s.Mode = ScanComments | SkipComments
+
+	tok, _, lit := s.Scan()
+	if tok != WS || lit != "\n" {
+		t.Fatalf("got (%v, %q), want (%v, %q)", tok, lit, WS, "\n")
+	}
+
+	tok, _, lit = s.Scan()
+	if tok != IDENT || lit != "foo" {
+		t.Fatalf("got (%v, %q), want (%v, %q)", tok, lit, IDENT, "foo")
+	}
+}
+
+func TestScanIdentUnicode(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"acheter du café", "acheter"},
+		{"přečíst knihu", "přečíst"},
+	}
+
+	for _, tt := range tests {
+		s := NewScanner(strings.NewReader(tt.input))
+		tok, _, lit := s.Scan()
+		if tok != IDENT || lit != tt.want {
+			t.Errorf("%q: got (%v, %q), want (%v, %q)", tt.input, tok, lit, IDENT, tt.want)
+		}
+	}
+}
+
+func TestScanCustomIsIdentRune(t *testing.T) {
+	s := NewScanner(strings.NewReader("foo-bar baz"))
+	s.IsIdentRune = func(ch rune, i int) bool {
+		return isLetter(ch) || isDigit(ch) || ch == '-'
+	}
+
+	tok, _, lit := s.Scan()
+	if tok != IDENT || lit != "foo-bar" {
+		t.Fatalf("got (%v, %q), want (%v, %q)", tok, lit, IDENT, "foo-bar")
+	}
+}
+
+func FuzzScan(f *testing.F) {
+	f.Add("buy milk\n")
+	f.Add("acheter du café")
+	f.Add("přečíst knihu\t42.5")
+	f.Add("# note\r\nfoo")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		s := NewScanner(strings.NewReader(input))
+		for i := 0; i < 10000; i++ {
+			tok, _, lit := s.Scan()
+			if tok == EOF {
+				return
+			}
+			if tok == ILLEGAL {
+				for _, ch := range lit {
+					if unicode.IsLetter(ch) || unicode.IsDigit(ch) || unicode.IsSpace(ch) {
+						t.Fatalf("ILLEGAL for %q, rune %q is letter/digit/space", lit, ch)
+					}
+				}
+			}
+		}
+	})
+}
+
+func TestScanPeek(t *testing.T) {
+	s := NewScanner(strings.NewReader("12/05"))
+
+	tok, lit := s.Peek()
+	if tok != NUMBER || lit != "12" {
+		t.Fatalf("Peek: got (%v, %q), want (%v, %q)", tok, lit, NUMBER, "12")
+	}
+
+	// Peeking again must not consume the token either.
+	tok, lit = s.Peek()
+	if tok != NUMBER || lit != "12" {
+		t.Fatalf("second Peek: got (%v, %q), want (%v, %q)", tok, lit, NUMBER, "12")
+	}
+
+	scanTok, _, scanLit := s.Scan()
+	if scanTok != NUMBER || scanLit != "12" {
+		t.Fatalf("Scan after Peek: got (%v, %q), want (%v, %q)", scanTok, scanLit, NUMBER, "12")
+	}
+}
+
+func TestScanUnscanTwoDeep(t *testing.T) {
+	// Mimics a parser that scans NUMBER SLASH NUMBER to tentatively try a
+	// date, decides against it, and must replay SLASH then NUMBER in their
+	// original order.
+	s := NewScanner(strings.NewReader("1/2"))
+
+	tok1, _, lit1 := s.Scan() // NUMBER "1"
+	tok2, _, lit2 := s.Scan() // SLASH "/"
+	tok3, _, lit3 := s.Scan() // NUMBER "2"
+	if tok1 != NUMBER || lit1 != "1" || tok2 != SLASH || lit2 != "/" || tok3 != NUMBER || lit3 != "2" {
+		t.Fatalf("got (%v,%q) (%v,%q) (%v,%q)", tok1, lit1, tok2, lit2, tok3, lit3)
+	}
+
+	s.Unscan() // give back NUMBER "2"
+	s.Unscan() // give back SLASH "/"
+
+	tok, _, lit := s.Scan()
+	if tok != SLASH || lit != "/" {
+		t.Fatalf("replay 1: got (%v, %q), want (%v, %q)", tok, lit, SLASH, "/")
+	}
+
+	tok, _, lit = s.Scan()
+	if tok != NUMBER || lit != "2" {
+		t.Fatalf("replay 2: got (%v, %q), want (%v, %q)", tok, lit, NUMBER, "2")
+	}
+
+	tok, _, lit = s.Scan()
+	if tok != EOF {
+		t.Fatalf("got (%v, %q), want EOF", tok, lit)
+	}
+}
+
+func TestScanNumber(t *testing.T) {
+	tests := []struct {
+		input string
+		toks  []Token
+		lits  []string
+	}{
+		{"4", []Token{NUMBER}, []string{"4"}},
+		{"4.99", []Token{NUMBER}, []string{"4.99"}},
+		{".5", []Token{NUMBER}, []string{".5"}},
+		{"1/2", []Token{NUMBER, SLASH, NUMBER}, []string{"1", "/", "2"}},
+		{"$4.99", []Token{CURRENCY_SIGN, NUMBER}, []string{"$", "4.99"}},
+		{"abc123", []Token{IDENT}, []string{"abc123"}},
+	}
+
+	for _, tt := range tests {
+		s := NewScanner(strings.NewReader(tt.input))
+		for i, wantTok := range tt.toks {
+			tok, _, lit := s.Scan()
+			if tok != wantTok || lit != tt.lits[i] {
+				t.Errorf("%q: token %d: got (%v, %q), want (%v, %q)", tt.input, i, tok, lit, wantTok, tt.lits[i])
+			}
+		}
+	}
+}